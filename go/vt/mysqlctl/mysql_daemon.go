@@ -7,6 +7,7 @@ package mysqlctl
 import (
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/youtube/vitess/go/vt/dbconfigs"
@@ -24,11 +25,22 @@ type MysqlDaemon interface {
 	// GetMysqlPort returns the current port mysql is listening on.
 	GetMysqlPort() (int, error)
 
+	// GetServerVersion returns the raw result of 'select version()', as
+	// queried once at startup and cached for the lifetime of the daemon.
+	// It is used to pick the right replication SQL dialect.
+	GetServerVersion() (string, error)
+
 	// replication related methods
 	StartSlave(hookExtraEnv map[string]string) error
 	StopSlave(hookExtraEnv map[string]string) error
 	SlaveStatus() (*proto.ReplicationStatus, error)
 
+	// ReplicationHealth augments SlaveStatus with the cross-checks
+	// needed to make a promotion decision: heartbeat-verified lag,
+	// errant GTIDs, and IO/SQL thread error codes broken out
+	// separately.
+	ReplicationHealth(ctx context.Context) (*proto.ReplicationHealth, error)
+
 	// reparenting related methods
 	BreakSlaves() error
 	MasterPosition() (proto.ReplicationPosition, error)
@@ -36,9 +48,36 @@ type MysqlDaemon interface {
 	StartReplicationCommands(status *proto.ReplicationStatus) ([]string, error)
 	WaitForReparentJournal(ctx context.Context, timeCreatedNS int64) error
 
+	// WaitUntilPosition waits until the replica's executed position is at
+	// least as far as pos, dispatching to WAIT_FOR_EXECUTED_GTID_SET on
+	// GTID-capable flavors and to MASTER_POS_WAIT otherwise.
+	WaitUntilPosition(ctx context.Context, pos proto.ReplicationPosition) error
+
+	// SkipTransaction resolves an errant transaction by injecting an
+	// empty transaction under the given GTID, so replication can move
+	// past it.
+	SkipTransaction(gtid string) error
+
+	// SetGTIDPurged sets gtid_purged, typically after a clone or a
+	// logical restore that doesn't preserve the binlog history.
+	SetGTIDPurged(gtids string) error
+
 	// Schema related methods
 	GetSchema(dbName string, tables, excludeTables []string, includeViews bool) (*proto.SchemaDefinition, error)
 
+	// backup/restore related methods
+
+	// CloneFrom uses the MySQL CLONE plugin to reseed the local data
+	// directory from a running donor, installing the plugin first if
+	// it isn't already. It blocks until the clone (and the restart it
+	// triggers) has completed.
+	CloneFrom(ctx context.Context, donorAddr, user, password, dataDir string) error
+
+	// CloneStatus returns the current progress of an in-flight or most
+	// recently completed CloneFrom, as reported by
+	// performance_schema.clone_status.
+	CloneStatus() (*proto.CloneStatus, error)
+
 	// GetDbConnection returns a connection to be able to talk to the database.
 	// It accepts a dbconfig name to determine which db user it the connection should have.
 	GetDbConnection(dbconfigName dbconfigs.DbConfigName) (dbconnpool.PoolConnection, error)
@@ -47,6 +86,98 @@ type MysqlDaemon interface {
 	ExecuteSuperQueryList(queryList []string) error
 }
 
+// MySQLFlavor identifies the replication SQL dialect a server speaks.
+// MySQL 8.4 renamed most of the replication vocabulary (SLAVE -> REPLICA,
+// MASTER -> SOURCE), so commands have to be generated against the flavor
+// detected at startup rather than hard-coded.
+type MySQLFlavor int
+
+const (
+	// FlavorLegacy covers MySQL 5.x/8.0 and the SLAVE/MASTER vocabulary.
+	FlavorLegacy MySQLFlavor = iota
+	// FlavorMySQL84 covers MySQL 8.4+, which uses REPLICA/SOURCE.
+	FlavorMySQL84
+)
+
+// flavorFromVersion maps a 'select version()' result to a MySQLFlavor.
+// The REPLICA/SOURCE vocabulary is a MySQL 8.4+ rename; forks that report
+// a MySQL-looking version number but aren't actually MySQL (MariaDB,
+// which stamps its own version onto the string, e.g. "10.11.6-MariaDB")
+// never speak it, so they're always treated as legacy.
+func flavorFromVersion(version string) MySQLFlavor {
+	if strings.Contains(strings.ToLower(version), "mariadb") {
+		return FlavorLegacy
+	}
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return FlavorLegacy
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return FlavorLegacy
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return FlavorLegacy
+	}
+	if major > 8 || (major == 8 && minor >= 4) {
+		return FlavorMySQL84
+	}
+	return FlavorLegacy
+}
+
+// replicationDialect holds the replication commands, CHANGE ... TO
+// parameter prefix, and the name of the Master_Host/Source_Host status
+// column for a given MySQLFlavor. Other renamed status columns are not
+// covered.
+//
+// This only covers the commands vitess's replication-setup and teardown
+// sequence actually emits; it is not a general SLAVE/MASTER-to-
+// REPLICA/SOURCE transliterator.
+type replicationDialect struct {
+	resetMaster      string
+	resetSlave       string
+	resetSlaveAll    string
+	startSlave       string
+	stopSlave        string
+	showSlaveStatus  string
+	changeMasterTo   string
+	paramPrefix      string // prefix of CHANGE ... TO parameters, e.g. MASTER_HOST
+	masterHostColumn string
+}
+
+var legacyDialect = replicationDialect{
+	resetMaster:      "RESET MASTER",
+	resetSlave:       "RESET SLAVE",
+	resetSlaveAll:    "RESET SLAVE ALL",
+	startSlave:       "START SLAVE",
+	stopSlave:        "STOP SLAVE",
+	showSlaveStatus:  "SHOW SLAVE STATUS",
+	changeMasterTo:   "CHANGE MASTER TO",
+	paramPrefix:      "MASTER_",
+	masterHostColumn: "Master_Host",
+}
+
+var mysql84Dialect = replicationDialect{
+	resetMaster:      "RESET BINARY LOGS AND GTIDS",
+	resetSlave:       "RESET REPLICA",
+	resetSlaveAll:    "RESET REPLICA ALL",
+	startSlave:       "START REPLICA",
+	stopSlave:        "STOP REPLICA",
+	showSlaveStatus:  "SHOW REPLICA STATUS",
+	changeMasterTo:   "CHANGE REPLICATION SOURCE TO",
+	paramPrefix:      "SOURCE_",
+	masterHostColumn: "Source_Host",
+}
+
+// dialectForFlavor returns the replicationDialect to use for a given flavor.
+func dialectForFlavor(flavor MySQLFlavor) replicationDialect {
+	if flavor == FlavorMySQL84 {
+		return mysql84Dialect
+	}
+	return legacyDialect
+}
+
 // FakeMysqlDaemon implements MysqlDaemon and allows the user to fake
 // everything.
 type FakeMysqlDaemon struct {
@@ -54,6 +185,15 @@ type FakeMysqlDaemon struct {
 	// ErrNotSlave, or to "ERROR" to return an error.
 	MasterAddr string
 
+	// ServerVersion is the raw 'select version()' result returned by
+	// GetServerVersion. It selects the replication dialect used to
+	// translate StartReplicationCommandsResult and to canonicalize
+	// queries seen by ExecuteSuperQueryList before comparing them
+	// against ExpectedExecuteSuperQueryList. Leave empty to get the
+	// legacy SLAVE/MASTER dialect; GetServerVersion then returns
+	// "5.6.24-log".
+	ServerVersion string
+
 	// MysqlPort will be returned by GetMysqlPort(). Set to -1 to
 	// return an error.
 	MysqlPort int
@@ -78,8 +218,9 @@ type FakeMysqlDaemon struct {
 	// StartReplicationCommands will return an error.
 	StartReplicationCommandsStatus *proto.ReplicationStatus
 
-	// StartReplicationCommandsResult is what
-	// StartReplicationCommands will return
+	// StartReplicationCommandsResult is what StartReplicationCommands
+	// will return, written against the legacy (canonical) dialect.
+	// It is translated into fmd's current dialect before being returned.
 	StartReplicationCommandsResult []string
 
 	// Schema that will be returned by GetSchema. If nil we'll
@@ -97,6 +238,51 @@ type FakeMysqlDaemon struct {
 	// match, ExecuteSuperQueryList will return an error.
 	// Note each string is just a substring if it begins with SUB
 	ExpectedExecuteSuperQueryList []string
+
+	// CloneFromCalled records whether CloneFrom was called.
+	CloneFromCalled bool
+
+	// CloneFromDonorAddr, CloneFromUser, CloneFromPassword,
+	// CloneFromDataDir record the arguments CloneFrom was last called
+	// with.
+	CloneFromDonorAddr string
+	CloneFromUser      string
+	CloneFromPassword  string
+	CloneFromDataDir   string
+
+	// CloneFromError is returned by CloneFrom
+	CloneFromError error
+
+	// CloneStatusResult is returned by CloneStatus. If nil, CloneStatus
+	// returns an error.
+	CloneStatusResult *proto.CloneStatus
+
+	// WaitUntilPositionRequested records the position WaitUntilPosition
+	// was last called with.
+	WaitUntilPositionRequested proto.ReplicationPosition
+
+	// WaitUntilPositionError is returned by WaitUntilPosition
+	WaitUntilPositionError error
+
+	// SkipTransactionGTID records the gtid SkipTransaction was last
+	// called with.
+	SkipTransactionGTID string
+
+	// SkipTransactionError is returned by SkipTransaction
+	SkipTransactionError error
+
+	// GTIDPurged records the value SetGTIDPurged was last called with.
+	GTIDPurged string
+
+	// SetGTIDPurgedError is returned by SetGTIDPurged
+	SetGTIDPurgedError error
+
+	// ReplicationHealthResult is returned by ReplicationHealth. If nil,
+	// ReplicationHealth returns an error.
+	ReplicationHealthResult *proto.ReplicationHealth
+
+	// ReplicationHealthError is returned by ReplicationHealth
+	ReplicationHealthError error
 }
 
 // GetMasterAddr is part of the MysqlDaemon interface
@@ -118,6 +304,19 @@ func (fmd *FakeMysqlDaemon) GetMysqlPort() (int, error) {
 	return fmd.MysqlPort, nil
 }
 
+// GetServerVersion is part of the MysqlDaemon interface
+func (fmd *FakeMysqlDaemon) GetServerVersion() (string, error) {
+	if fmd.ServerVersion == "" {
+		return "5.6.24-log", nil
+	}
+	return fmd.ServerVersion, nil
+}
+
+// dialect returns the replicationDialect implied by fmd.ServerVersion.
+func (fmd *FakeMysqlDaemon) dialect() replicationDialect {
+	return dialectForFlavor(flavorFromVersion(fmd.ServerVersion))
+}
+
 // StartSlave is part of the MysqlDaemon interface
 func (fmd *FakeMysqlDaemon) StartSlave(hookExtraEnv map[string]string) error {
 	fmd.Replicating = true
@@ -138,6 +337,17 @@ func (fmd *FakeMysqlDaemon) SlaveStatus() (*proto.ReplicationStatus, error) {
 	return fmd.CurrentSlaveStatus, nil
 }
 
+// ReplicationHealth is part of the MysqlDaemon interface
+func (fmd *FakeMysqlDaemon) ReplicationHealth(ctx context.Context) (*proto.ReplicationHealth, error) {
+	if fmd.ReplicationHealthError != nil {
+		return nil, fmd.ReplicationHealthError
+	}
+	if fmd.ReplicationHealthResult == nil {
+		return nil, fmt.Errorf("no replication health defined")
+	}
+	return fmd.ReplicationHealthResult, nil
+}
+
 // BreakSlaves is part of the MysqlDaemon interface
 func (fmd *FakeMysqlDaemon) BreakSlaves() error {
 	return fmd.BreakSlavesError
@@ -159,7 +369,12 @@ func (fmd *FakeMysqlDaemon) StartReplicationCommands(status *proto.ReplicationSt
 	if !reflect.DeepEqual(fmd.StartReplicationCommandsStatus, status) {
 		return nil, fmt.Errorf("wrong status for StartReplicationCommands: expected %v got %v", fmd.StartReplicationCommandsStatus, status)
 	}
-	return fmd.StartReplicationCommandsResult, nil
+	d := fmd.dialect()
+	result := make([]string, len(fmd.StartReplicationCommandsResult))
+	for i, query := range fmd.StartReplicationCommandsResult {
+		result[i] = translateDialect(query, legacyDialect, d)
+	}
+	return result, nil
 }
 
 // WaitForReparentJournal is part of the MysqlDaemon interface
@@ -167,6 +382,24 @@ func (fmd *FakeMysqlDaemon) WaitForReparentJournal(ctx context.Context, timeCrea
 	return nil
 }
 
+// WaitUntilPosition is part of the MysqlDaemon interface
+func (fmd *FakeMysqlDaemon) WaitUntilPosition(ctx context.Context, pos proto.ReplicationPosition) error {
+	fmd.WaitUntilPositionRequested = pos
+	return fmd.WaitUntilPositionError
+}
+
+// SkipTransaction is part of the MysqlDaemon interface
+func (fmd *FakeMysqlDaemon) SkipTransaction(gtid string) error {
+	fmd.SkipTransactionGTID = gtid
+	return fmd.SkipTransactionError
+}
+
+// SetGTIDPurged is part of the MysqlDaemon interface
+func (fmd *FakeMysqlDaemon) SetGTIDPurged(gtids string) error {
+	fmd.GTIDPurged = gtids
+	return fmd.SetGTIDPurgedError
+}
+
 // ExecuteSuperQueryList is part of the MysqlDaemon interface
 func (fmd *FakeMysqlDaemon) ExecuteSuperQueryList(queryList []string) error {
 	if len(queryList) != len(fmd.ExpectedExecuteSuperQueryList) {
@@ -176,8 +409,16 @@ func (fmd *FakeMysqlDaemon) ExecuteSuperQueryList(queryList []string) error {
 	compGot := make([]string, len(queryList))
 	for i, expected := range fmd.ExpectedExecuteSuperQueryList {
 		if strings.HasPrefix(expected, "SUB") {
+			// ExpectedExecuteSuperQueryList is always written against
+			// the legacy (canonical) dialect, so translate what we
+			// actually got back to canonical form before comparing.
 			compExpected[i] = expected[3:]
-			compGot[i] = queryList[i][:len(compExpected[i])]
+			got := fmd.canonicalizeQuery(queryList[i])
+			if len(got) < len(compExpected[i]) {
+				compGot[i] = got
+			} else {
+				compGot[i] = got[:len(compExpected[i])]
+			}
 		}
 	}
 	if !reflect.DeepEqual(compExpected, compGot) {
@@ -186,6 +427,49 @@ func (fmd *FakeMysqlDaemon) ExecuteSuperQueryList(queryList []string) error {
 	return nil
 }
 
+// translateDialect rewrites a query from one replicationDialect to
+// another: command verbs, the CHANGE ... TO parameter prefix (e.g.
+// SOURCE_HOST -> MASTER_HOST), and the renamed status column.
+//
+// Keywords are only rewritten outside of quoted string literals, so a
+// value like MASTER_HOST='db-MASTER_01' is left untouched.
+func translateDialect(query string, from, to replicationDialect) string {
+	if from == to {
+		return query
+	}
+	// Splitting on ' alternates unquoted/quoted spans (assuming the
+	// simple, unescaped literals StartReplicationCommands generates).
+	// Only rewrite the even (unquoted) spans.
+	spans := strings.Split(query, "'")
+	for i := 0; i < len(spans); i += 2 {
+		spans[i] = translateDialectKeywords(spans[i], from, to)
+	}
+	return strings.Join(spans, "'")
+}
+
+// translateDialectKeywords rewrites the replication command verbs and
+// identifiers of an unquoted query span from one replicationDialect to
+// another.
+func translateDialectKeywords(span string, from, to replicationDialect) string {
+	span = strings.Replace(span, from.resetMaster, to.resetMaster, 1)
+	span = strings.Replace(span, from.resetSlaveAll, to.resetSlaveAll, 1)
+	span = strings.Replace(span, from.resetSlave, to.resetSlave, 1)
+	span = strings.Replace(span, from.startSlave, to.startSlave, 1)
+	span = strings.Replace(span, from.stopSlave, to.stopSlave, 1)
+	span = strings.Replace(span, from.showSlaveStatus, to.showSlaveStatus, 1)
+	span = strings.Replace(span, from.changeMasterTo, to.changeMasterTo, 1)
+	span = strings.Replace(span, from.paramPrefix, to.paramPrefix, -1)
+	span = strings.Replace(span, from.masterHostColumn, to.masterHostColumn, -1)
+	return span
+}
+
+// canonicalizeQuery rewrites a query emitted in fmd's current dialect back
+// to the legacy SLAVE/MASTER vocabulary, so tests can be written once
+// against the canonical form regardless of fmd.ServerVersion.
+func (fmd *FakeMysqlDaemon) canonicalizeQuery(query string) string {
+	return translateDialect(query, fmd.dialect(), legacyDialect)
+}
+
 // GetSchema is part of the MysqlDaemon interface
 func (fmd *FakeMysqlDaemon) GetSchema(dbName string, tables, excludeTables []string, includeViews bool) (*proto.SchemaDefinition, error) {
 	if fmd.Schema == nil {
@@ -194,6 +478,24 @@ func (fmd *FakeMysqlDaemon) GetSchema(dbName string, tables, excludeTables []str
 	return fmd.Schema.FilterTables(tables, excludeTables, includeViews)
 }
 
+// CloneFrom is part of the MysqlDaemon interface
+func (fmd *FakeMysqlDaemon) CloneFrom(ctx context.Context, donorAddr, user, password, dataDir string) error {
+	fmd.CloneFromCalled = true
+	fmd.CloneFromDonorAddr = donorAddr
+	fmd.CloneFromUser = user
+	fmd.CloneFromPassword = password
+	fmd.CloneFromDataDir = dataDir
+	return fmd.CloneFromError
+}
+
+// CloneStatus is part of the MysqlDaemon interface
+func (fmd *FakeMysqlDaemon) CloneStatus() (*proto.CloneStatus, error) {
+	if fmd.CloneStatusResult == nil {
+		return nil, fmt.Errorf("no clone status defined")
+	}
+	return fmd.CloneStatusResult, nil
+}
+
 // GetDbConnection is part of the MysqlDaemon interface
 func (fmd *FakeMysqlDaemon) GetDbConnection(dbconfigName dbconfigs.DbConfigName) (dbconnpool.PoolConnection, error) {
 	switch dbconfigName {